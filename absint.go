@@ -1,19 +1,24 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
-	"os"
-	"os/exec"
-	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/anchore/syft/syft/sbom"
 	"github.com/xlab/treeprint"
+
+	"github.com/chewxy/cloudbuildval/runtime"
 )
 
+// maxConcurrentSteps bounds how many steps run at once within a single
+// waitFor batch, mirroring Cloud Build's own worker pool rather than
+// spawning one goroutine per step unconditionally.
+const maxConcurrentSteps = 8
+
 type pathtree struct {
 	parent   *pathtree // nil means root
 	name     string
@@ -49,32 +54,73 @@ func newPathtree(name string, parent *pathtree) *pathtree {
 	return p
 }
 
-type mutFn func(s *State, args ...string) error
+// cursor is a step's private view onto the shared pathtree: its own cwd,
+// plus the State whose root (and mutex) it mutates through. Running steps
+// concurrently means each needs its own cwd cursor rather than sharing one
+// State.cwd field, while mkdir'd directories still merge into the single
+// shared root.
+type cursor struct {
+	ai  *State
+	cwd *pathtree
+
+	// dirStack backs pushd/popd. It's per-cursor rather than per-State since
+	// each concurrently running step has its own notion of "current" stack.
+	dirStack []*pathtree
+}
+
+type mutFn func(c *cursor, args ...string) error
 
 type thunk struct {
 	mutFn
 	args []string
+
+	// orGuarded marks a thunk parsed from the left-hand side of a shell
+	// `||`, whose error is swallowed instead of aborting the step -- the
+	// same way a failing left-hand command in `cmd || true` doesn't fail
+	// the shell.
+	orGuarded bool
 }
 
 // State is a representation the cloudbuild container as the steps are being abstractly interpreted.
 type State struct {
 	root *pathtree
-	cwd  *pathtree
+	// workspace is cached at construction so concurrent steps can seed their
+	// cwd without a `root.children["workspace"]` map read racing against
+	// other steps' mkdir'd siblings of "workspace" under mu.
+	workspace *pathtree
+
+	mu sync.Mutex // guards root's tree mutations
 
 	containers map[string]*sbom.SBOM
 
 	replacements map[string]string
+
+	Runtime runtime.ContainerRuntime
+
+	// SBOMFormat forces the decoder used for SBOMs instead of auto-detecting
+	// from content. Empty means auto-detect.
+	SBOMFormat string
+	// SBOMSource selects where each step's SBOM comes from: "" re-scans the
+	// pulled image via Runtime, "sibling" looks for a pre-generated SBOM file
+	// next to the cloudbuild.yaml, and "attestation" fetches a signed SBOM
+	// attestation referrer.
+	SBOMSource string
+	// SBOMSourceDir is the directory the cloudbuild.yaml lives in, used to
+	// resolve sibling SBOM files.
+	SBOMSourceDir string
 }
 
-func NewState(replacements map[string]string) *State {
+func NewState(replacements map[string]string, rt runtime.ContainerRuntime) *State {
 	root := newPathtree("root", nil)
-	newPathtree("workspace", root)
+	workspace := newPathtree("workspace", root)
 	return &State{
-		root: root,
-		cwd:  root,
+		root:      root,
+		workspace: workspace,
 
 		containers:   make(map[string]*sbom.SBOM),
 		replacements: replacements,
+
+		Runtime: rt,
 	}
 }
 
@@ -90,30 +136,41 @@ func (ai *State) ensureSteps(steps []Step) error {
 			continue
 		}
 		log.Printf("Pulling %v", step.Name)
-		cmd := exec.Command("docker", "pull", step.Name)
-		err := cmd.Run()
-		if err != nil {
-			return errors.Join(fmt.Errorf("Unable to ensure %v", step.Name), err, cmd.Err)
+		if err := ai.Runtime.Pull(step.Name); err != nil {
+			return errors.Join(fmt.Errorf("Unable to ensure %v", step.Name), err)
 		}
 
 		log.Printf("Inspecting %v", step.Name)
 		// now that we have pulled the image, we can inspect it
-		if err = ai.inspectImage(&steps[i]); err != nil {
+		if err := ai.inspectImage(&steps[i]); err != nil {
 			return err
 		}
 
 		log.Printf("Compiling SBOM for %v", step.Name)
 
 		// compile the SBOM
-		if err = ai.compileSBOM(step); err != nil {
+		if err := ai.compileSBOM(step); err != nil {
 			return err
 		}
 
 	}
+
+	// waitFor is validated here, before any steps run, so
+	// that a dangling id or a dependency cycle is reported as a validation
+	// error rather than a deadlock.
+	_, deps, err := buildDependencyGraph(steps)
+	if err != nil {
+		return err
+	}
+	if _, err := topoBatches(deps); err != nil {
+		return err
+	}
+
 	// check paths
+	c := &cursor{ai: ai, cwd: ai.workspace}
 	for _, step := range steps {
 		if step.Dir != "" {
-			ai.setDir(step)
+			c.setDir(step)
 		}
 	}
 	for _, step := range steps {
@@ -142,26 +199,18 @@ func (ai *State) replaceStr(s string) string {
 }
 
 func (ai *State) inspectImage(step *Step) (err error) {
-	cmd := exec.Command("docker", "inspect", step.Name)
-	out, err := cmd.Output()
-	if err != nil {
-		return errors.Join(fmt.Errorf("Unable to inspect %v", step.Name), err, cmd.Err)
-	}
-	var inspection []Inspection
-	err = json.Unmarshal(out, &inspection)
+	inspection, err := ai.Runtime.Inspect(step.Name)
 	if err != nil {
-		return err
-	}
-	if len(inspection) != 1 {
-		return fmt.Errorf("Expected 1 inspection, got %d", len(inspection))
+		return errors.Join(fmt.Errorf("Unable to inspect %v", step.Name), err)
 	}
+	step.digest = inspection.Id
 	// setting value - use the convention `steps[i]` instead of `step`
 	// because sideeffects are cool bro (that was sarcasm)
-	if len(step.cmd) > 0 {
-		step.cmd = inspection[0].Config.Cmd[0]
+	if len(inspection.Config.Cmd) > 0 {
+		step.cmd = inspection.Config.Cmd[0]
 	}
-	if step.Entrypoint == "" {
-		step.Entrypoint = inspection[0].Config.Entrypoint[0]
+	if step.Entrypoint == "" && len(inspection.Config.Entrypoint) > 0 {
+		step.Entrypoint = inspection.Config.Entrypoint[0]
 	}
 	if step.Entrypoint == "" {
 		step.Entrypoint = step.cmd
@@ -173,21 +222,36 @@ func (ai *State) inspectImage(step *Step) (err error) {
 }
 
 func (ai *State) compileSBOM(step *Step) (err error) {
-	// now we compile SBOM
-	filename := step.Name + ".json"
-	cmd := exec.Command("docker", "sbom", step.Name, "--format", "syft-json", "-o", filename)
-	if err = cmd.Run(); err != nil {
-		return errors.Join(fmt.Errorf("Unable to fetch SBOM for %v", step.Name), err)
+	r, err := ai.sbomReader(step)
+	if err != nil {
+		return err
 	}
+	defer r.Close()
 
-	f, err := os.Open(filename)
+	bom, err := getBOM(r, ai.SBOMFormat)
 	if err != nil {
-		return errors.Join(fmt.Errorf("Unable to open SBOM file %v", filename), err)
+		return errors.Join(fmt.Errorf("Unable to decode SBOM for %v", step.Name), err)
 	}
-
-	bom := getBOM(f)
 	ai.containers[step.Name] = bom
-	return f.Close()
+	return nil
+}
+
+// sbomReader returns the raw SBOM bytes for step, sourced according to
+// ai.SBOMSource.
+func (ai *State) sbomReader(step *Step) (io.ReadCloser, error) {
+	switch ai.SBOMSource {
+	case "":
+		// compile the SBOM, streaming it straight from the runtime rather
+		// than writing it to a file in the current working directory (which
+		// used to pollute the repo and break on refs containing "/")
+		return ai.Runtime.SBOM(step.Name, "syft-json")
+	case "sibling":
+		return siblingSBOM(ai.SBOMSourceDir, step.digest)
+	case "attestation":
+		return attestationSBOM(step.Name)
+	default:
+		return nil, fmt.Errorf("unknown --sbom-source %v", ai.SBOMSource)
+	}
 }
 
 func (ai *State) checkEntrypoint(step Step) error {
@@ -202,18 +266,18 @@ func (ai *State) checkEntrypoint(step Step) error {
 // workspaceDir is a method that takes a raw directory string and spits out a list of directories to traverse through.
 //
 // PLENTY OF SIDE EFFECTS
-func (ai *State) workspaceDir(raw string, setWorkspace bool) []string {
+func (c *cursor) workspaceDir(raw string, setWorkspace bool) []string {
 	dir := strings.Split(raw, "/")
 	idx := 1
 	switch dir[0] {
 	case "":
-		ai.cwd = ai.root
+		c.cwd = c.ai.root
 	case ".":
 	case "..":
-		ai.cwd = ai.cwd.parent
+		c.cwd = c.cwd.parent
 	default:
 		if setWorkspace {
-			ai.cwd = ai.root.children["workspace"]
+			c.cwd = c.ai.workspace
 		}
 		idx = 0
 	}
@@ -221,27 +285,75 @@ func (ai *State) workspaceDir(raw string, setWorkspace bool) []string {
 	return dir
 }
 
-func (ai *State) setDir(s Step) {
+func (c *cursor) setDir(s Step) {
 	if s.Dir == "" {
 		return // cwd it is!
 	}
-	dir := ai.workspaceDir(s.Dir, true)
+	dir := c.workspaceDir(s.Dir, true)
 	for _, d := range dir {
-		mkdir(ai, d)
-		cd(ai, d)
+		mkdir(c, d)
+		cd(c, d)
 	}
 }
 
-func (ai *State) execute(s Step) error {
+func (c *cursor) execute(s Step) error {
 	// directory related ones are executed
-	ai.setDir(s)
+	c.setDir(s)
 	if isShell(s.Entrypoint) {
-		ts := parseShellArgs(s.Args)
+		ts, err := parseShellArgs(c.ai, s.Args)
+		if err != nil {
+			return errors.Join(fmt.Errorf("Unable to parse shell script for %v", s.Name), err)
+		}
 		for _, t := range ts {
 			if t.mutFn == nil {
 				continue
 			}
-			if err := t.mutFn(ai, t.args...); err != nil {
+			if err := t.mutFn(c, t.args...); err != nil && !t.orGuarded {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// executeSteps runs steps honoring each step's waitFor dependencies: steps
+// in the same DAG level run concurrently (bounded by maxConcurrentSteps).
+// Every step gets its own cwd cursor seeded fresh from the workspace root,
+// matching real Cloud Build where only the shared volume's files (not a
+// predecessor's shell cwd) carry over between steps; mkdir'd directories
+// merge into the single shared root under State.mu.
+func (ai *State) executeSteps(steps []Step) error {
+	_, deps, err := buildDependencyGraph(steps)
+	if err != nil {
+		return err
+	}
+	batches, err := topoBatches(deps)
+	if err != nil {
+		return err
+	}
+
+	sem := make(chan struct{}, maxConcurrentSteps)
+	for _, batch := range batches {
+		var wg sync.WaitGroup
+		errs := make([]error, len(batch))
+		for bi, idx := range batch {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(bi, idx int) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				step := steps[idx]
+				c := &cursor{ai: ai, cwd: ai.workspace}
+				if err := c.execute(step); err != nil {
+					errs[bi] = errors.Join(fmt.Errorf("Failed to run %v", step.Name), err)
+					return
+				}
+			}(bi, idx)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
 				return err
 			}
 		}
@@ -249,6 +361,90 @@ func (ai *State) execute(s Step) error {
 	return nil
 }
 
+// buildDependencyGraph resolves each step's waitFor into step indices,
+// defaulting to depending on every previously defined step (Cloud Build's
+// implicit sequential behaviour) when waitFor is unset, and to no
+// dependencies when waitFor is exactly ["-"]. It returns an error if a
+// waitFor references an id that doesn't exist.
+func buildDependencyGraph(steps []Step) (ids []string, deps [][]int, err error) {
+	ids = make([]string, len(steps))
+	idToIdx := make(map[string]int, len(steps))
+	for i, s := range steps {
+		id := s.ID
+		if id == "" {
+			id = fmt.Sprintf("step-%d", i)
+		}
+		if _, exists := idToIdx[id]; exists {
+			return nil, nil, fmt.Errorf("duplicate step id %q", id)
+		}
+		ids[i] = id
+		idToIdx[id] = i
+	}
+
+	deps = make([][]int, len(steps))
+	for i, s := range steps {
+		switch {
+		case len(s.WaitFor) == 1 && s.WaitFor[0] == "-":
+			// no dependencies: this step starts immediately
+		case len(s.WaitFor) == 0:
+			for j := 0; j < i; j++ {
+				deps[i] = append(deps[i], j)
+			}
+		default:
+			for _, want := range s.WaitFor {
+				j, ok := idToIdx[want]
+				if !ok {
+					return nil, nil, fmt.Errorf("step %v waitFor references unknown id %q", ids[i], want)
+				}
+				deps[i] = append(deps[i], j)
+			}
+		}
+	}
+	return ids, deps, nil
+}
+
+// topoBatches groups step indices into levels that can run concurrently,
+// via Kahn's algorithm. A non-nil error means the waitFor graph has a
+// cycle, since some steps would never have their dependencies satisfied.
+func topoBatches(deps [][]int) ([][]int, error) {
+	n := len(deps)
+	indegree := make([]int, n)
+	dependents := make([][]int, n)
+	for i, ds := range deps {
+		indegree[i] = len(ds)
+		for _, d := range ds {
+			dependents[d] = append(dependents[d], i)
+		}
+	}
+
+	var batches [][]int
+	remaining := n
+	var ready []int
+	for i := 0; i < n; i++ {
+		if indegree[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	for len(ready) > 0 {
+		batches = append(batches, ready)
+		remaining -= len(ready)
+		var next []int
+		for _, i := range ready {
+			for _, dep := range dependents[i] {
+				indegree[dep]--
+				if indegree[dep] == 0 {
+					next = append(next, dep)
+				}
+			}
+		}
+		ready = next
+	}
+	if remaining > 0 {
+		return nil, fmt.Errorf("cycle detected in step waitFor graph")
+	}
+	return batches, nil
+}
+
 func isShell(entrypoint string) bool {
 	switch entrypoint {
 	case "/bin/bash", "/bin/sh", "sh":
@@ -258,25 +454,21 @@ func isShell(entrypoint string) bool {
 	}
 }
 
-func parseShellArgs(args []string) (retVal []thunk) {
-	if args[0] == "-c" {
-		args = strings.Split(args[1], "\n")
-	}
-	for _, arg := range args {
-		s := strings.Split(arg, " ")
-		fn := dirCmds[s[0]]
-		args := s[1:]
-		retVal = append(retVal, thunk{fn, args})
-	}
-	return retVal
-}
-
 var dirCmds = map[string]mutFn{
 	"mkdir": mkdir,
 	"cd":    cd,
+	"pushd": pushd,
+	"popd":  popd,
+	"rm":    rm,
+	"cp":    cp,
+	"mv":    mv,
+	"touch": touch,
 }
 
-func mkdir(ai *State, args ...string) error {
+// mkdir walks to (and, with -p, creates) the parent of p's leaf, the same
+// way resolveParent does, so that creating a nested directory never leaves
+// c.cwd parked somewhere other than where the step started.
+func mkdir(c *cursor, args ...string) error {
 	// clean args of \n first
 	for i := range args {
 		args[i] = strings.Trim(args[i], "\n")
@@ -289,45 +481,208 @@ func mkdir(ai *State, args ...string) error {
 		}
 	}
 	p := args[len(args)-1]
-	l := ai.workspaceDir(p, false)
+
+	c.ai.mu.Lock()
+	defer c.ai.mu.Unlock()
+
+	local := &cursor{ai: c.ai, cwd: c.cwd}
+	l := local.workspaceDir(p, false)
 
 	for i, x := range l {
 		if i == len(l)-1 {
-			if _, ok := ai.cwd.children[x]; ok {
+			if _, ok := local.cwd.children[x]; ok {
 				return nil
 			}
-			newPathtree(x, ai.cwd)
+			newPathtree(x, local.cwd)
 			return nil
 		}
-		if err := cd(ai, x); err != nil {
+		next, ok := local.cwd.children[x]
+		if !ok {
 			if !dashp {
-				return errors.Join(fmt.Errorf("Cannot mkdir %v. Perhaps you didn't pass in -p?", p), err)
+				return fmt.Errorf("Cannot mkdir %v. Perhaps you didn't pass in -p?", p)
 			}
-			mkdir(ai, x) // no error will occur
-			cd(ai, x)    // no error will occur
+			next = newPathtree(x, local.cwd)
 		}
+		local.cwd = next
 	}
 	return nil
 
 }
 
-func cd(ai *State, args ...string) error {
+func cd(c *cursor, args ...string) error {
 	p := args[0]
-	l := filepath.SplitList(p)
+	l := c.workspaceDir(p, false)
+
+	c.ai.mu.Lock()
+	defer c.ai.mu.Unlock()
+
 	for _, x := range l {
-		cwd, ok := ai.cwd.children[x]
+		cwd, ok := c.cwd.children[x]
 		if !ok {
 			return fmt.Errorf("path not found: %v", p)
 		}
-		ai.cwd = cwd
+		c.cwd = cwd
+	}
+	return nil
+}
+
+func pushd(c *cursor, args ...string) error {
+	c.dirStack = append(c.dirStack, c.cwd)
+	if len(args) == 0 {
+		return nil
+	}
+	if err := cd(c, args...); err != nil {
+		c.dirStack = c.dirStack[:len(c.dirStack)-1]
+		return err
+	}
+	return nil
+}
+
+func popd(c *cursor, args ...string) error {
+	if len(c.dirStack) == 0 {
+		return fmt.Errorf("popd: directory stack empty")
+	}
+	last := len(c.dirStack) - 1
+	c.cwd = c.dirStack[last]
+	c.dirStack = c.dirStack[:last]
+	return nil
+}
+
+// resolveParent walks to the directory containing the leaf of raw, the same
+// way mkdir/cd do, and returns that parent node alongside the leaf name
+// without creating anything. It traverses a throwaway copy of c so that
+// resolving one path (e.g. the source of a cp) never leaves c.cwd parked
+// somewhere unexpected for the rest of the step's script. Callers must hold
+// c.ai.mu.
+func (c *cursor) resolveParent(raw string) (parent *pathtree, name string, err error) {
+	local := &cursor{ai: c.ai, cwd: c.cwd}
+	segments := local.workspaceDir(raw, false)
+	if len(segments) == 0 {
+		return nil, "", fmt.Errorf("empty path")
+	}
+	for _, seg := range segments[:len(segments)-1] {
+		next, ok := local.cwd.children[seg]
+		if !ok {
+			return nil, "", fmt.Errorf("path not found: %v", raw)
+		}
+		local.cwd = next
+	}
+	return local.cwd, segments[len(segments)-1], nil
+}
+
+func nonFlagArgs(args []string) []string {
+	var paths []string
+	for _, a := range args {
+		if strings.HasPrefix(a, "-") {
+			continue
+		}
+		paths = append(paths, a)
+	}
+	return paths
+}
+
+// rm prunes subtrees from the pathtree, modeling `rm -rf`.
+func rm(c *cursor, args ...string) error {
+	c.ai.mu.Lock()
+	defer c.ai.mu.Unlock()
+
+	for _, p := range nonFlagArgs(args) {
+		parent, name, err := c.resolveParent(p)
+		if err != nil {
+			return err
+		}
+		delete(parent.children, name)
+	}
+	return nil
+}
+
+// touch creates leaf nodes for any paths that don't already exist.
+func touch(c *cursor, args ...string) error {
+	c.ai.mu.Lock()
+	defer c.ai.mu.Unlock()
+
+	for _, p := range nonFlagArgs(args) {
+		parent, name, err := c.resolveParent(p)
+		if err != nil {
+			return err
+		}
+		if _, ok := parent.children[name]; !ok {
+			newPathtree(name, parent)
+		}
+	}
+	return nil
+}
+
+// cloneSubtree deep-copies node (and its descendants) as a new child named
+// name under parent.
+func cloneSubtree(node, parent *pathtree, name string) {
+	clone := newPathtree(name, parent)
+	for childName, child := range node.children {
+		cloneSubtree(child, clone, childName)
+	}
+}
+
+// cp deep-copies a subtree to a new path, modeling `cp -r`.
+func cp(c *cursor, args ...string) error {
+	paths := nonFlagArgs(args)
+	if len(paths) < 2 {
+		return fmt.Errorf("cp: missing destination")
+	}
+	src, dst := paths[0], paths[len(paths)-1]
+
+	c.ai.mu.Lock()
+	defer c.ai.mu.Unlock()
+
+	srcParent, srcName, err := c.resolveParent(src)
+	if err != nil {
+		return err
+	}
+	node, ok := srcParent.children[srcName]
+	if !ok {
+		return fmt.Errorf("cp: no such file or directory: %v", src)
+	}
+
+	dstParent, dstName, err := c.resolveParent(dst)
+	if err != nil {
+		return err
+	}
+	cloneSubtree(node, dstParent, dstName)
+	return nil
+}
+
+// mv renames/moves a subtree, modeling `mv`.
+func mv(c *cursor, args ...string) error {
+	paths := nonFlagArgs(args)
+	if len(paths) < 2 {
+		return fmt.Errorf("mv: missing destination")
+	}
+	src, dst := paths[0], paths[len(paths)-1]
+
+	c.ai.mu.Lock()
+	defer c.ai.mu.Unlock()
+
+	srcParent, srcName, err := c.resolveParent(src)
+	if err != nil {
+		return err
+	}
+	node, ok := srcParent.children[srcName]
+	if !ok {
+		return fmt.Errorf("mv: no such file or directory: %v", src)
+	}
+
+	dstParent, dstName, err := c.resolveParent(dst)
+	if err != nil {
+		return err
 	}
+	cloneSubtree(node, dstParent, dstName)
+	delete(srcParent.children, srcName)
 	return nil
 }
 
-func gitclone(ai *State, args ...string) error {
+func gitclone(c *cursor, args ...string) error {
 	return nil
 }
 
-func gitfetch(ai *State, args ...string) error {
+func gitfetch(c *cursor, args ...string) error {
 	return nil
 }
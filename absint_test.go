@@ -0,0 +1,256 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildDependencyGraph(t *testing.T) {
+	tests := []struct {
+		name     string
+		steps    []Step
+		wantIDs  []string
+		wantDeps [][]int
+		wantErr  bool
+	}{
+		{
+			name:     "implicit sequential",
+			steps:    []Step{{ID: "a"}, {ID: "b"}, {ID: "c"}},
+			wantIDs:  []string{"a", "b", "c"},
+			wantDeps: [][]int{nil, {0}, {0, 1}},
+		},
+		{
+			name:     "dash means no dependencies",
+			steps:    []Step{{ID: "a"}, {ID: "b", WaitFor: []string{"-"}}},
+			wantIDs:  []string{"a", "b"},
+			wantDeps: [][]int{nil, nil},
+		},
+		{
+			name:     "explicit waitFor",
+			steps:    []Step{{ID: "a"}, {ID: "b"}, {ID: "c", WaitFor: []string{"a"}}},
+			wantIDs:  []string{"a", "b", "c"},
+			wantDeps: [][]int{nil, {0}, {0}},
+		},
+		{
+			name:    "unknown waitFor id",
+			steps:   []Step{{ID: "a", WaitFor: []string{"nope"}}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate id",
+			steps:   []Step{{ID: "a"}, {ID: "a"}},
+			wantErr: true,
+		},
+		{
+			name:     "unnamed steps get synthesized ids",
+			steps:    []Step{{}, {}},
+			wantIDs:  []string{"step-0", "step-1"},
+			wantDeps: [][]int{nil, {0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, deps, err := buildDependencyGraph(tt.steps)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("buildDependencyGraph() err = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildDependencyGraph() err = %v, want nil", err)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+			if !reflect.DeepEqual(deps, tt.wantDeps) {
+				t.Errorf("deps = %v, want %v", deps, tt.wantDeps)
+			}
+		})
+	}
+}
+
+func TestTopoBatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		deps    [][]int
+		want    [][]int
+		wantErr bool
+	}{
+		{
+			name: "linear chain batches one at a time",
+			deps: [][]int{nil, {0}, {1}},
+			want: [][]int{{0}, {1}, {2}},
+		},
+		{
+			name: "independent steps share a batch",
+			deps: [][]int{nil, nil, {0, 1}},
+			want: [][]int{{0, 1}, {2}},
+		},
+		{
+			name:    "cycle is an error",
+			deps:    [][]int{{1}, {0}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := topoBatches(tt.deps)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("topoBatches() err = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("topoBatches() err = %v, want nil", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("topoBatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestCursor() *cursor {
+	ai := NewState(nil, nil)
+	return &cursor{ai: ai, cwd: ai.workspace}
+}
+
+func TestTouchCreatesLeaf(t *testing.T) {
+	c := newTestCursor()
+	if err := touch(c, "foo.txt"); err != nil {
+		t.Fatalf("touch() err = %v", err)
+	}
+	if _, ok := c.cwd.children["foo.txt"]; !ok {
+		t.Fatalf("touch() did not create foo.txt")
+	}
+
+	// touching an existing leaf is a no-op, not an error.
+	if err := touch(c, "foo.txt"); err != nil {
+		t.Fatalf("touch() on existing leaf err = %v", err)
+	}
+}
+
+func TestRmPrunesSubtree(t *testing.T) {
+	c := newTestCursor()
+	if err := mkdir(c, "-p", "a/b"); err != nil {
+		t.Fatalf("mkdir() err = %v", err)
+	}
+	if err := rm(c, "a/b"); err != nil {
+		t.Fatalf("rm() err = %v", err)
+	}
+	a, ok := c.cwd.children["a"]
+	if !ok {
+		t.Fatalf("rm() unexpectedly removed a")
+	}
+	if _, ok := a.children["b"]; ok {
+		t.Fatalf("rm() did not remove a/b")
+	}
+}
+
+func TestRmDoesNotCorruptCwd(t *testing.T) {
+	// Regression test: resolveParent used to permanently walk c.cwd to the
+	// parent of each resolved path, so a nested rm/cp/mv/touch left cwd
+	// parked somewhere other than where the step started.
+	c := newTestCursor()
+	if err := mkdir(c, "-p", "a/b"); err != nil {
+		t.Fatalf("mkdir() err = %v", err)
+	}
+	start := c.cwd
+	if err := rm(c, "a/b"); err != nil {
+		t.Fatalf("rm() err = %v", err)
+	}
+	if c.cwd != start {
+		t.Fatalf("rm() moved c.cwd from %v to %v", start, c.cwd)
+	}
+}
+
+func TestCpCopiesSubtree(t *testing.T) {
+	c := newTestCursor()
+	if err := mkdir(c, "-p", "a/b"); err != nil {
+		t.Fatalf("mkdir() err = %v", err)
+	}
+	if err := touch(c, "a/b/file.txt"); err != nil {
+		t.Fatalf("touch() err = %v", err)
+	}
+	if err := cp(c, "a", "c"); err != nil {
+		t.Fatalf("cp() err = %v", err)
+	}
+
+	cNode, ok := c.cwd.children["c"]
+	if !ok {
+		t.Fatalf("cp() did not create destination c")
+	}
+	b, ok := cNode.children["b"]
+	if !ok {
+		t.Fatalf("cp() did not copy nested dir b")
+	}
+	if _, ok := b.children["file.txt"]; !ok {
+		t.Fatalf("cp() did not copy nested file")
+	}
+	// original must still be present: cp, unlike mv, doesn't delete the source.
+	if _, ok := c.cwd.children["a"]; !ok {
+		t.Fatalf("cp() removed the source subtree")
+	}
+}
+
+func TestMvMovesSubtree(t *testing.T) {
+	c := newTestCursor()
+	if err := mkdir(c, "-p", "a"); err != nil {
+		t.Fatalf("mkdir() err = %v", err)
+	}
+	if err := touch(c, "a/file.txt"); err != nil {
+		t.Fatalf("touch() err = %v", err)
+	}
+	if err := mv(c, "a", "z"); err != nil {
+		t.Fatalf("mv() err = %v", err)
+	}
+	if _, ok := c.cwd.children["a"]; ok {
+		t.Fatalf("mv() left the source subtree behind")
+	}
+	z, ok := c.cwd.children["z"]
+	if !ok {
+		t.Fatalf("mv() did not create destination z")
+	}
+	if _, ok := z.children["file.txt"]; !ok {
+		t.Fatalf("mv() did not carry over the nested file")
+	}
+}
+
+func TestCdSplitsOnSlash(t *testing.T) {
+	// Regression test: cd used to split on the OS PATH-list separator
+	// instead of "/", so any multi-segment target failed to resolve.
+	c := newTestCursor()
+	if err := mkdir(c, "-p", "build/output"); err != nil {
+		t.Fatalf("mkdir() err = %v", err)
+	}
+	if err := cd(c, "build/output"); err != nil {
+		t.Fatalf("cd() err = %v", err)
+	}
+	if c.cwd.name != "output" {
+		t.Fatalf("cd() landed on %v, want output", c.cwd.name)
+	}
+}
+
+func TestPushdPopd(t *testing.T) {
+	c := newTestCursor()
+	if err := mkdir(c, "-p", "build/output"); err != nil {
+		t.Fatalf("mkdir() err = %v", err)
+	}
+	start := c.cwd
+	if err := pushd(c, "build/output"); err != nil {
+		t.Fatalf("pushd() err = %v", err)
+	}
+	if c.cwd.name != "output" {
+		t.Fatalf("pushd() landed on %v, want output", c.cwd.name)
+	}
+	if err := popd(c); err != nil {
+		t.Fatalf("popd() err = %v", err)
+	}
+	if c.cwd != start {
+		t.Fatalf("popd() restored %v, want %v", c.cwd, start)
+	}
+}
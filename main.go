@@ -2,17 +2,21 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	yaml "gopkg.in/yaml.v2"
 
 	flag "github.com/spf13/pflag"
+
+	"github.com/chewxy/cloudbuildval/runtime"
 )
 
 var (
@@ -21,6 +25,9 @@ var (
 	repoNameFlag      = flag.String("repo-name", "", "The repo name to use for the cloudbuild.yaml file")
 	branchNameFlag    = flag.String("branch-name", "", "The branch name to use for the cloudbuild.yaml file")
 	tagNameFlag       = flag.String("tag-name", "", "The tag name to use for the cloudbuild.yaml file")
+	runtimeFlag       = flag.String("runtime", "", "The container runtime to use: docker, podman, or buildah (auto-detected from $PATH if unset)")
+	sbomFormatFlag    = flag.String("sbom-format", "", "Force the SBOM decoder to use instead of auto-detecting from content: syft-json, cyclonedx-json, cyclonedx-xml, spdx-json, or spdx-tag-value")
+	sbomSourceFlag    = flag.String("sbom-source", "", "Where to obtain each step's SBOM from: \"\" (default, re-scan the pulled image via the container runtime), \"sibling\" (look for a <digest>.cdx.json / <digest>.spdx.json next to the cloudbuild.yaml), or \"attestation\" (fetch a signed SBOM attestation referrer via oras/crane)")
 )
 
 type Cloudbuild struct {
@@ -34,21 +41,10 @@ type Step struct {
 	Entrypoint string   `yaml:"entrypoint"`
 	Args       []string `yaml:"args"`
 	Dir        string   `yaml:"dir"`
+	WaitFor    []string `yaml:"waitFor"`
 
-	cmd string // the CMD of the image, if found.
-}
-
-// Inspection is the output of `docker inspect`
-type Inspection struct {
-	Id      string `json:"Id"`
-	Created string `json:"Created"`
-	Name    string `json:"Name"`
-	Config  struct {
-		Entrypoint []string `json:"Entrypoint"`
-		Cmd        []string `json:"Cmd"`
-	} `json:"Config"`
-	Architecture string `json:"Architecture"`
-	Os           string `json:"Os"`
+	cmd    string // the CMD of the image, if found.
+	digest string // the image digest/Id, as reported by the container runtime.
 }
 
 func readCloudbuildYAML(filename string) (*Cloudbuild, error) {
@@ -99,36 +95,90 @@ func buildReplacements() map[string]string {
 		m["$REPO_NAME"] = filepath.Base(dir)
 	}
 
+	branch, commit, tag, err := gitInfo(dir, *tagNameFlag == "")
+	if err != nil {
+		log.Printf("Unable to read git info from %v via go-git, falling back to shelling out: %v", dir, err)
+		branch, commit = gitInfoFallback(dir)
+	}
+
 	// branch name
 	if *branchNameFlag != "" {
 		m["$BRANCH_NAME"] = *branchNameFlag
-	} else {
-		// try to get from git
-		cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
-		cmd.Dir = dir
-		out, err := cmd.Output()
-		if err != nil {
-			log.Fatal(err)
-		}
-		m["$BRANCH_NAME"] = string(out)
+	} else if branch != "" {
+		m["$BRANCH_NAME"] = branch
 	}
 
 	// tag name
 	if *tagNameFlag != "" {
 		m["$TAG_NAME"] = *tagNameFlag
+	} else if tag != "" {
+		m["$TAG_NAME"] = tag
 	}
 
-	// try to get commit hash
-	cmd := exec.Command("git", "rev-parse", "HEAD")
-	cmd.Dir = dir
+	if commit != "" {
+		m["$COMMIT"] = commit
+		m["$SHORT_SHA"] = commit[:7]
+	}
+	return m
+}
 
-	out, err := cmd.Output()
+// gitInfo reads the branch name, commit hash, and (if findTag is true and HEAD
+// is tagged) the tag name of the repository at dir using an in-process git
+// implementation, so that validation can run inside containers without a
+// `git` binary on PATH. It returns an error if dir isn't inside a git repo.
+func gitInfo(dir string, findTag bool) (branch, commit, tag string, err error) {
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
 	if err != nil {
-		return m
+		return "", "", "", err
 	}
-	m["$COMMIT"] = string(out)
-	m["$SHORT_SHA"] = string(out)[:7]
-	return m
+
+	head, err := repo.Head()
+	if err != nil {
+		return "", "", "", err
+	}
+	branch = head.Name().Short()
+	commit = head.Hash().String()
+
+	if findTag {
+		tags, err := repo.Tags()
+		if err == nil {
+			tags.ForEach(func(ref *plumbing.Reference) error {
+				if ref.Hash() == head.Hash() {
+					tag = ref.Name().Short()
+				}
+				return nil
+			})
+		}
+	}
+
+	return branch, commit, tag, nil
+}
+
+// gitInfoFallback shells out to the `git` binary, preserving the old
+// behaviour for working directories go-git fails to open (e.g. bare
+// worktrees or formats it doesn't yet support).
+func gitInfoFallback(dir string) (branch, commit string) {
+	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
+	cmd.Dir = dir
+	if out, err := cmd.Output(); err == nil {
+		branch = strings.TrimSpace(string(out))
+	}
+
+	cmd = exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	if out, err := cmd.Output(); err == nil {
+		commit = strings.TrimSpace(string(out))
+	}
+	return branch, commit
+}
+
+// selectRuntime resolves the --runtime flag (if set) to a runtime.ContainerRuntime,
+// otherwise auto-detects one from $PATH.
+func selectRuntime(name string) (runtime.ContainerRuntime, error) {
+	if name == "" {
+		return runtime.Detect()
+	}
+	return runtime.New(runtime.Name(name))
 }
 
 func main() {
@@ -144,17 +194,23 @@ func main() {
 		log.Fatal(err)
 	}
 
-	s := NewState(replacements)
+	rt, err := selectRuntime(*runtimeFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := NewState(replacements, rt)
+	s.SBOMFormat = *sbomFormatFlag
+	s.SBOMSource = *sbomSourceFlag
+	s.SBOMSourceDir = filepath.Dir(filename)
 	steps := cb.Steps
 	// ensure that the steps have all the relevant information
 	if err := s.ensureSteps(steps); err != nil {
 		log.Fatal(err)
 	}
 
-	for _, step := range steps {
-		if err := s.execute(step); err != nil {
-			log.Fatal(errors.Join(fmt.Errorf("Failed to run %v", step.Name), err))
-		}
+	if err := s.executeSteps(steps); err != nil {
+		log.Fatal(err)
 	}
 	log.Printf("Final state:\n%v", s.root)
 
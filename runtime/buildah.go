@@ -0,0 +1,67 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// BuildahRuntime shells out to the buildah CLI.
+type BuildahRuntime struct{}
+
+func (BuildahRuntime) Pull(ref string) error {
+	cmd := exec.Command("buildah", "pull", ref)
+	if err := cmd.Run(); err != nil {
+		return errors.Join(fmt.Errorf("unable to pull %v", ref), err)
+	}
+	return nil
+}
+
+func (BuildahRuntime) Inspect(ref string) (Inspection, error) {
+	cmd := exec.Command("buildah", "inspect", "--type", "image", ref)
+	out, err := cmd.Output()
+	if err != nil {
+		return Inspection{}, errors.Join(fmt.Errorf("unable to inspect %v", ref), err)
+	}
+	// buildah inspect returns a single object, not an array like docker/podman.
+	var raw struct {
+		OCIv1 struct {
+			Created      string `json:"created"`
+			Architecture string `json:"architecture"`
+			Os           string `json:"os"`
+			Config       struct {
+				Entrypoint []string `json:"Entrypoint"`
+				Cmd        []string `json:"Cmd"`
+			} `json:"config"`
+		} `json:"OCIv1"`
+		FromImageID string `json:"FromImageID"`
+	}
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return Inspection{}, err
+	}
+	inspection := Inspection{
+		Id:           raw.FromImageID,
+		Created:      raw.OCIv1.Created,
+		Name:         ref,
+		Architecture: raw.OCIv1.Architecture,
+		Os:           raw.OCIv1.Os,
+	}
+	inspection.Config.Entrypoint = raw.OCIv1.Config.Entrypoint
+	inspection.Config.Cmd = raw.OCIv1.Config.Cmd
+	return inspection, nil
+}
+
+// SBOM shells out to syft directly, since buildah has no `sbom` subcommand.
+func (BuildahRuntime) SBOM(ref string, format string) (io.ReadCloser, error) {
+	cmd := exec.Command("syft", ref, "-o", format)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Join(fmt.Errorf("unable to fetch SBOM for %v", ref), err)
+	}
+	return &cmdReadCloser{ReadCloser: out, cmd: cmd}, nil
+}
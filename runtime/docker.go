@@ -0,0 +1,64 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// DockerRuntime shells out to the docker CLI.
+type DockerRuntime struct{}
+
+func (DockerRuntime) Pull(ref string) error {
+	cmd := exec.Command("docker", "pull", ref)
+	if err := cmd.Run(); err != nil {
+		return errors.Join(fmt.Errorf("unable to pull %v", ref), err)
+	}
+	return nil
+}
+
+func (DockerRuntime) Inspect(ref string) (Inspection, error) {
+	cmd := exec.Command("docker", "inspect", ref)
+	out, err := cmd.Output()
+	if err != nil {
+		return Inspection{}, errors.Join(fmt.Errorf("unable to inspect %v", ref), err)
+	}
+	var inspection []Inspection
+	if err := json.Unmarshal(out, &inspection); err != nil {
+		return Inspection{}, err
+	}
+	if len(inspection) != 1 {
+		return Inspection{}, fmt.Errorf("expected 1 inspection, got %d", len(inspection))
+	}
+	return inspection[0], nil
+}
+
+func (DockerRuntime) SBOM(ref string, format string) (io.ReadCloser, error) {
+	cmd := exec.Command("docker", "sbom", ref, "--format", format, "-o", "-")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Join(fmt.Errorf("unable to fetch SBOM for %v", ref), err)
+	}
+	return &cmdReadCloser{ReadCloser: out, cmd: cmd}, nil
+}
+
+// cmdReadCloser waits on the underlying command once its stdout pipe is
+// closed, so callers can treat SBOM output like any other io.ReadCloser.
+type cmdReadCloser struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (c *cmdReadCloser) Close() error {
+	closeErr := c.ReadCloser.Close()
+	waitErr := c.cmd.Wait()
+	if closeErr != nil {
+		return closeErr
+	}
+	return waitErr
+}
@@ -0,0 +1,51 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// PodmanRuntime shells out to the podman CLI. `podman image inspect` emits
+// the same JSON shape as `docker inspect`, so Inspection decodes unchanged.
+type PodmanRuntime struct{}
+
+func (PodmanRuntime) Pull(ref string) error {
+	cmd := exec.Command("podman", "pull", ref)
+	if err := cmd.Run(); err != nil {
+		return errors.Join(fmt.Errorf("unable to pull %v", ref), err)
+	}
+	return nil
+}
+
+func (PodmanRuntime) Inspect(ref string) (Inspection, error) {
+	cmd := exec.Command("podman", "image", "inspect", ref)
+	out, err := cmd.Output()
+	if err != nil {
+		return Inspection{}, errors.Join(fmt.Errorf("unable to inspect %v", ref), err)
+	}
+	var inspection []Inspection
+	if err := json.Unmarshal(out, &inspection); err != nil {
+		return Inspection{}, err
+	}
+	if len(inspection) != 1 {
+		return Inspection{}, fmt.Errorf("expected 1 inspection, got %d", len(inspection))
+	}
+	return inspection[0], nil
+}
+
+// SBOM shells out to syft directly, since podman has no built-in `sbom`
+// subcommand.
+func (PodmanRuntime) SBOM(ref string, format string) (io.ReadCloser, error) {
+	cmd := exec.Command("syft", ref, "-o", format)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Join(fmt.Errorf("unable to fetch SBOM for %v", ref), err)
+	}
+	return &cmdReadCloser{ReadCloser: out, cmd: cmd}, nil
+}
@@ -0,0 +1,79 @@
+// Package runtime abstracts over the container CLI used to pull images,
+// inspect their config, and fetch their SBOM, so that cloudbuildval isn't
+// hard-wired to docker and can run wherever only podman or buildah is
+// available (rootless CI runners, air-gapped builders, etc).
+package runtime
+
+import (
+	"io"
+	"os/exec"
+)
+
+// Inspection is the shape common to `docker inspect`, `podman image inspect`,
+// and `buildah inspect --type image` output that cloudbuildval cares about.
+type Inspection struct {
+	Id      string `json:"Id"`
+	Created string `json:"Created"`
+	Name    string `json:"Name"`
+	Config  struct {
+		Entrypoint []string `json:"Entrypoint"`
+		Cmd        []string `json:"Cmd"`
+	} `json:"Config"`
+	Architecture string `json:"Architecture"`
+	Os           string `json:"Os"`
+}
+
+// ContainerRuntime is implemented by each supported container tool.
+type ContainerRuntime interface {
+	// Pull fetches ref to the local machine.
+	Pull(ref string) error
+	// Inspect returns the image config for ref.
+	Inspect(ref string) (Inspection, error)
+	// SBOM returns the SBOM for ref in the given format, streamed rather than
+	// written to a file so callers can decode it directly.
+	SBOM(ref string, format string) (io.ReadCloser, error)
+}
+
+// Name identifies a supported container runtime backend.
+type Name string
+
+const (
+	Docker  Name = "docker"
+	Podman  Name = "podman"
+	Buildah Name = "buildah"
+)
+
+// New constructs the ContainerRuntime for name.
+func New(name Name) (ContainerRuntime, error) {
+	switch name {
+	case Docker:
+		return DockerRuntime{}, nil
+	case Podman:
+		return PodmanRuntime{}, nil
+	case Buildah:
+		return BuildahRuntime{}, nil
+	default:
+		return nil, &UnsupportedRuntimeError{Name: name}
+	}
+}
+
+// UnsupportedRuntimeError is returned by New and Detect when no known
+// container runtime matches.
+type UnsupportedRuntimeError struct {
+	Name Name
+}
+
+func (e *UnsupportedRuntimeError) Error() string {
+	return "unsupported container runtime: " + string(e.Name)
+}
+
+// Detect picks a ContainerRuntime by looking for docker, podman, and buildah
+// on $PATH, in that order of preference.
+func Detect() (ContainerRuntime, error) {
+	for _, name := range []Name{Docker, Podman, Buildah} {
+		if _, err := exec.LookPath(string(name)); err == nil {
+			return New(name)
+		}
+	}
+	return nil, &UnsupportedRuntimeError{Name: "(none found on $PATH)"}
+}
@@ -1,22 +1,191 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 
+	"github.com/anchore/syft/syft/format"
+	"github.com/anchore/syft/syft/format/cyclonedxjson"
+	"github.com/anchore/syft/syft/format/cyclonedxxml"
+	"github.com/anchore/syft/syft/format/spdxjson"
+	"github.com/anchore/syft/syft/format/spdxtagvalue"
 	"github.com/anchore/syft/syft/format/syftjson"
 	"github.com/anchore/syft/syft/pkg"
 	"github.com/anchore/syft/syft/sbom"
 )
 
-func getBOM(f io.Reader) *sbom.SBOM {
-	dec := syftjson.NewFormatDecoder()
-	bom, formatID, version, err := dec.Decode(f)
+// sbomDecoders are the formats cloudbuildval understands, tried in order
+// until one sniffs the content as its own. syft-json stays first since it's
+// the default `docker sbom` / Runtime.SBOM output.
+func sbomDecoders() []sbom.FormatDecoder {
+	return []sbom.FormatDecoder{
+		syftjson.NewFormatDecoder(),
+		cyclonedxjson.NewFormatDecoder(),
+		cyclonedxxml.NewFormatDecoder(),
+		spdxjson.NewFormatDecoder(),
+		spdxtagvalue.NewFormatDecoder(),
+	}
+}
+
+// sbomDecoderByFormat looks up a single decoder by its --sbom-format name.
+func sbomDecoderByFormat(name string) (sbom.FormatDecoder, error) {
+	switch name {
+	case "syft-json":
+		return syftjson.NewFormatDecoder(), nil
+	case "cyclonedx-json":
+		return cyclonedxjson.NewFormatDecoder(), nil
+	case "cyclonedx-xml":
+		return cyclonedxxml.NewFormatDecoder(), nil
+	case "spdx-json":
+		return spdxjson.NewFormatDecoder(), nil
+	case "spdx-tag-value":
+		return spdxtagvalue.NewFormatDecoder(), nil
+	default:
+		return nil, fmt.Errorf("unknown SBOM format %v", name)
+	}
+}
+
+// getBOM decodes an SBOM, which may be a pre-generated CycloneDX or SPDX
+// file rather than syft's own JSON. formatOverride forces a specific
+// decoder instead of sniffing the content.
+func getBOM(f io.Reader, formatOverride string) (*sbom.SBOM, error) {
+	if formatOverride != "" {
+		dec, err := sbomDecoderByFormat(formatOverride)
+		if err != nil {
+			return nil, err
+		}
+		bom, _, _, err := dec.Decode(f)
+		return bom, err
+	}
+
+	dec := format.NewDecoderCollection(sbomDecoders()...)
+	bom, _, _, err := dec.Decode(f)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	return bom, nil
+}
+
+// siblingSBOM looks for a pre-generated <digest>.cdx.json or <digest>.spdx.json
+// next to the cloudbuild.yaml, for builds that validate against signed
+// provenance rather than re-scanning the image on each run.
+func siblingSBOM(dir, digest string) (io.ReadCloser, error) {
+	if digest == "" {
+		return nil, fmt.Errorf("no image digest recorded, cannot locate a sibling SBOM")
+	}
+	digest = strings.TrimPrefix(digest, "sha256:")
+	for _, suffix := range []string{".cdx.json", ".spdx.json"} {
+		p := filepath.Join(dir, digest+suffix)
+		if f, err := os.Open(p); err == nil {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("no sibling SBOM found for %v in %v", digest, dir)
+}
+
+// sbomReferrerArtifactTypes are the OCI referrer artifactTypes this
+// validator recognizes as SBOM attestations, tried in order against `oras
+// discover` until one turns up a referrer.
+var sbomReferrerArtifactTypes = []string{
+	"application/vnd.cyclonedx+json",
+	"application/spdx+json",
+}
+
+// attestationSBOM fetches a signed SBOM attestation referrer for ref,
+// rather than re-scanning the image. Discovery always goes through oras,
+// since it's the one of the two tools that speaks the OCI Referrers API;
+// the matched referrer's blob is then fetched via crane or oras, whichever
+// is on $PATH.
+func attestationSBOM(ref string) (io.ReadCloser, error) {
+	if !lookPathOK("oras") {
+		return nil, fmt.Errorf("oras not found on $PATH to discover an SBOM attestation for %v", ref)
+	}
+
+	digest, err := discoverSBOMReferrer(ref)
+	if err != nil {
+		return nil, err
+	}
+	blobRef := ref + "@" + digest
+
+	switch {
+	case lookPathOK("crane"):
+		cmd := exec.Command("crane", "blob", blobRef)
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, errors.Join(fmt.Errorf("unable to fetch attestation blob %v", blobRef), err)
+		}
+		return &cmdReadCloser{ReadCloser: out, cmd: cmd}, nil
+	case lookPathOK("oras"):
+		cmd := exec.Command("oras", "blob", "fetch", "--output", "-", blobRef)
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, errors.Join(fmt.Errorf("unable to fetch attestation blob %v", blobRef), err)
+		}
+		return &cmdReadCloser{ReadCloser: out, cmd: cmd}, nil
+	default:
+		return nil, fmt.Errorf("neither crane nor oras found on $PATH to fetch an SBOM attestation for %v", ref)
+	}
+}
+
+// discoverSBOMReferrer asks oras for ref's referrers of each known SBOM
+// artifactType in turn, returning the digest of the first match.
+func discoverSBOMReferrer(ref string) (string, error) {
+	for _, artifactType := range sbomReferrerArtifactTypes {
+		cmd := exec.Command("oras", "discover", "--artifact-type", artifactType, "-o", "json", ref)
+		out, err := cmd.Output()
+		if err != nil {
+			continue
+		}
+
+		var discovery struct {
+			Manifests []struct {
+				Digest string `json:"digest"`
+			} `json:"manifests"`
+		}
+		if err := json.Unmarshal(out, &discovery); err != nil {
+			continue
+		}
+		if len(discovery.Manifests) > 0 {
+			return discovery.Manifests[0].Digest, nil
+		}
 	}
-	_ = formatID
-	_ = version
-	return bom
+	return "", fmt.Errorf("no SBOM attestation referrer found for %v", ref)
+}
+
+// cmdReadCloser waits on the underlying command once its stdout pipe is
+// closed, so callers can treat attestation output like any other
+// io.ReadCloser without leaking a zombie process. Mirrors
+// runtime.cmdReadCloser, which package main can't reuse directly since it's
+// unexported.
+type cmdReadCloser struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (c *cmdReadCloser) Close() error {
+	closeErr := c.ReadCloser.Close()
+	waitErr := c.cmd.Wait()
+	if closeErr != nil {
+		return closeErr
+	}
+	return waitErr
+}
+
+func lookPathOK(bin string) bool {
+	_, err := exec.LookPath(bin)
+	return err == nil
 }
 
 func findEntrypoint(b *sbom.SBOM, entrypoint string) bool {
@@ -31,20 +200,77 @@ func findEntrypoint(b *sbom.SBOM, entrypoint string) bool {
 			return true
 		}
 	}
-	return false
+
+	// Plenty of catalogers (tarball COPY, language-native package managers
+	// without a Files list, etc.) don't record executable ownership in
+	// package-specific metadata at all. Fall back to the SBOM's own file
+	// index, which syft populates independently of which package (if any)
+	// claims the file.
+	return findEntrypointInFileMetadata(b, entrypoint)
 }
 
 func findEntrypointInMetadata(m any, entrypoint string) bool {
 	switch r := m.(type) {
 	case pkg.DpkgDBEntry:
-		return inDpkgPath(r, entrypoint)
+		return inPaths(entrypoint, dpkgPaths(r))
+	case pkg.RpmDBEntry:
+		return inPaths(entrypoint, rpmPaths(r))
+	case pkg.ApkDBEntry:
+		return inPaths(entrypoint, apkPaths(r))
+	case pkg.PythonPackage:
+		return inPaths(entrypoint, pythonPaths(r))
+	case pkg.GolangBinaryBuildinfoEntry:
+		return r.MainModule != "" && (entrypoint == r.MainModule || strings.HasSuffix(entrypoint, "/"+r.MainModule))
 	}
 	return false
 }
 
-func inDpkgPath(r pkg.DpkgDBEntry, want string) bool {
+func inPaths(want string, paths []string) bool {
+	for _, p := range paths {
+		if p == want {
+			return true
+		}
+	}
+	return false
+}
+
+func dpkgPaths(r pkg.DpkgDBEntry) []string {
+	paths := make([]string, 0, len(r.Files))
+	for _, f := range r.Files {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
+
+func rpmPaths(r pkg.RpmDBEntry) []string {
+	paths := make([]string, 0, len(r.Files))
+	for _, f := range r.Files {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
+
+func apkPaths(r pkg.ApkDBEntry) []string {
+	paths := make([]string, 0, len(r.Files))
+	for _, f := range r.Files {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
+
+func pythonPaths(r pkg.PythonPackage) []string {
+	paths := make([]string, 0, len(r.Files))
 	for _, f := range r.Files {
-		if f.Path == want {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
+
+// findEntrypointInFileMetadata walks the SBOM's file index directly, rather
+// than going through any specific package's metadata.
+func findEntrypointInFileMetadata(b *sbom.SBOM, entrypoint string) bool {
+	for coords := range b.Artifacts.FileMetadata {
+		if coords.RealPath == entrypoint {
 			return true
 		}
 	}
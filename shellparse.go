@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// parseShellArgs parses a Cloud Build step's `-c` shell script with a real
+// POSIX shell parser instead of naively splitting on newlines and spaces, so
+// quoted arguments, `&&`/`||`/`;` sequencing, `{ ...; }` groups, and `export
+// VAR=...` assignments are all understood rather than mangled. It walks
+// every *syntax.CallExpr in the script, resolving each word against
+// ai.replacements and any assignments observed earlier in the same script.
+// A CallExpr on the left-hand side of a `||` is marked tolerant so that its
+// thunk's error doesn't abort the rest of the step, mirroring how a failing
+// left-hand command in `cmd || true` doesn't fail the shell -- the `||`
+// itself is what's handling the failure.
+func parseShellArgs(ai *State, args []string) ([]thunk, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+
+	script := args[0]
+	if args[0] == "-c" && len(args) > 1 {
+		script = args[1]
+	}
+
+	f, err := syntax.NewParser().Parse(strings.NewReader(script), "")
+	if err != nil {
+		return nil, err
+	}
+
+	assigns := make(map[string]string)
+	orGuarded := make(map[syntax.Node]bool)
+	var retVal []thunk
+
+	syntax.Walk(f, func(node syntax.Node) bool {
+		if bc, ok := node.(*syntax.BinaryCmd); ok && bc.Op == syntax.OrStmt {
+			markOrGuarded(bc.X, orGuarded)
+		}
+
+		// `export VAR=...` parses as a DeclClause, not a CallExpr.
+		if dc, ok := node.(*syntax.DeclClause); ok && dc.Variant != nil && dc.Variant.Value == "export" {
+			for _, a := range dc.Args {
+				if a.Name == nil || a.Value == nil {
+					continue
+				}
+				assigns[a.Name.Value] = resolveWord(a.Value, assigns, ai.replacements)
+			}
+			return true
+		}
+
+		ce, ok := node.(*syntax.CallExpr)
+		if !ok {
+			return true
+		}
+
+		for _, a := range ce.Assigns {
+			if a.Name == nil {
+				continue
+			}
+			val := ""
+			if a.Value != nil {
+				val = resolveWord(a.Value, assigns, ai.replacements)
+			}
+			assigns[a.Name.Value] = val
+		}
+
+		if len(ce.Args) == 0 {
+			return false
+		}
+
+		words := make([]string, len(ce.Args))
+		for i, w := range ce.Args {
+			words[i] = resolveWord(w, assigns, ai.replacements)
+		}
+
+		if words[0] == "export" {
+			for _, kv := range words[1:] {
+				if eq := strings.IndexByte(kv, '='); eq >= 0 {
+					assigns[kv[:eq]] = kv[eq+1:]
+				}
+			}
+			return false
+		}
+
+		retVal = append(retVal, thunk{dirCmds[words[0]], words[1:], orGuarded[node]})
+		return false
+	})
+
+	return retVal, nil
+}
+
+// markOrGuarded flags node and everything nested under it as the left-hand
+// side of a `||`, so the thunk(s) built from it can swallow a failure
+// instead of aborting the step the way an un-guarded command would.
+func markOrGuarded(node syntax.Node, guarded map[syntax.Node]bool) {
+	syntax.Walk(node, func(n syntax.Node) bool {
+		guarded[n] = true
+		return true
+	})
+}
+
+// resolveWord expands a parsed shell word against known variable
+// assignments and State.replacements, falling back to the empty string for
+// anything it can't resolve.
+func resolveWord(w *syntax.Word, assigns, replacements map[string]string) string {
+	var sb strings.Builder
+	for _, part := range w.Parts {
+		sb.WriteString(resolveWordPart(part, assigns, replacements))
+	}
+	return sb.String()
+}
+
+func resolveWordPart(part syntax.WordPart, assigns, replacements map[string]string) string {
+	switch p := part.(type) {
+	case *syntax.Lit:
+		return p.Value
+	case *syntax.SglQuoted:
+		return p.Value
+	case *syntax.DblQuoted:
+		var sb strings.Builder
+		for _, inner := range p.Parts {
+			sb.WriteString(resolveWordPart(inner, assigns, replacements))
+		}
+		return sb.String()
+	case *syntax.ParamExp:
+		if p.Param == nil {
+			return ""
+		}
+		name := p.Param.Value
+		if v, ok := assigns[name]; ok {
+			return v
+		}
+		if v, ok := replacements["$"+name]; ok {
+			return v
+		}
+		return ""
+	default:
+		return ""
+	}
+}
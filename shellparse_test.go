@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestParseShellArgsBasic(t *testing.T) {
+	ai := NewState(nil, nil)
+	ts, err := parseShellArgs(ai, []string{"-c", "mkdir -p out && touch out/f.txt"})
+	if err != nil {
+		t.Fatalf("parseShellArgs() err = %v", err)
+	}
+	if len(ts) != 2 {
+		t.Fatalf("len(ts) = %d, want 2", len(ts))
+	}
+	if len(ts[0].args) == 0 || ts[0].args[len(ts[0].args)-1] != "out" {
+		t.Errorf("thunk[0].args = %v, want last arg \"out\"", ts[0].args)
+	}
+	if len(ts[1].args) == 0 || ts[1].args[0] != "out/f.txt" {
+		t.Errorf("thunk[1].args = %v, want [\"out/f.txt\"]", ts[1].args)
+	}
+	for i, th := range ts {
+		if th.orGuarded {
+			t.Errorf("thunk[%d].orGuarded = true, want false (no || in script)", i)
+		}
+	}
+}
+
+func TestParseShellArgsOrGuardsLeftHandSide(t *testing.T) {
+	ai := NewState(nil, nil)
+	ts, err := parseShellArgs(ai, []string{"-c", "rm -rf build || true"})
+	if err != nil {
+		t.Fatalf("parseShellArgs() err = %v", err)
+	}
+	if len(ts) != 2 {
+		t.Fatalf("len(ts) = %d, want 2 (rm, true)", len(ts))
+	}
+	if !ts[0].orGuarded {
+		t.Errorf("thunk[0] (rm, left of ||) orGuarded = false, want true")
+	}
+}
+
+func TestParseShellArgsExport(t *testing.T) {
+	ai := NewState(map[string]string{}, nil)
+	ts, err := parseShellArgs(ai, []string{"-c", "export FOO=bar && mkdir $FOO"})
+	if err != nil {
+		t.Fatalf("parseShellArgs() err = %v", err)
+	}
+	if len(ts) != 1 {
+		t.Fatalf("len(ts) = %d, want 1 (export produces no thunk)", len(ts))
+	}
+	if len(ts[0].args) == 0 || ts[0].args[0] != "bar" {
+		t.Errorf("thunk[0].args = %v, want exported $FOO resolved to \"bar\"", ts[0].args)
+	}
+}
+
+func TestParseShellArgsEmpty(t *testing.T) {
+	ai := NewState(nil, nil)
+	ts, err := parseShellArgs(ai, nil)
+	if err != nil {
+		t.Fatalf("parseShellArgs() err = %v", err)
+	}
+	if ts != nil {
+		t.Errorf("ts = %v, want nil", ts)
+	}
+}